@@ -0,0 +1,76 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// TestCrawlQueueDrainsAndTerminates exercises the push/done pairing that
+// crawlQueue relies on to self-close: a handful of workers pop synthetic
+// jobs that themselves spawn children (as getWebContent does for
+// discovered links) down to a fixed depth. If pending/done ever get out of
+// step, this either hangs (queue never closes) or a worker gets a job count
+// that doesn't match what was pushed, so both are checked against a
+// timeout.
+func TestCrawlQueueDrainsAndTerminates(t *testing.T) {
+    const (
+        seeds          = 5
+        workers        = 4
+        maxDepth       = 3
+        childrenPerJob = 2
+    )
+
+    q := newCrawlQueue()
+    var processed int64
+
+    for i := 0; i < seeds; i++ {
+        q.push(crawlJob{url: fmt.Sprintf("seed%d", i), depth: 0})
+    }
+    q.markReaderDone()
+
+    var wg sync.WaitGroup
+    wg.Add(workers)
+    for i := 0; i < workers; i++ {
+        go func() {
+            defer wg.Done()
+            for {
+                job, ok := q.pop()
+                if !ok {
+                    return
+                }
+                atomic.AddInt64(&processed, 1)
+                if job.depth < maxDepth {
+                    for c := 0; c < childrenPerJob; c++ {
+                        q.push(crawlJob{url: job.url + "/child", depth: job.depth + 1})
+                    }
+                }
+                q.done()
+            }
+        }()
+    }
+
+    drained := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(drained)
+    }()
+
+    select {
+    case <-drained:
+    case <-time.After(5 * time.Second):
+        t.Fatal("crawlQueue never drained/closed — pending/done pairing is broken")
+    }
+
+    want := 0
+    level := seeds
+    for d := 0; d <= maxDepth; d++ {
+        want += level
+        level *= childrenPerJob
+    }
+    if got := int(processed); got != want {
+        t.Fatalf("processed %d jobs, want %d", got, want)
+    }
+}
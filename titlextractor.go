@@ -3,18 +3,35 @@ package main
 import (
     "bufio"
     "context"
+    "crypto/sha256"
     "crypto/tls"
+    "crypto/x509"
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "io"
+    "math/rand"
     "net"
     "net/http"
+    "net/url"
     "os"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "github.com/quic-go/quic-go/http3"
     "golang.org/x/net/html"
+    "golang.org/x/net/html/charset"
+    "golang.org/x/net/http2"
+    "golang.org/x/time/rate"
 )
 
 const (
@@ -25,65 +42,736 @@ const (
     colorMagenta = "\033[35m"
 )
 
+// outputFormat selects how results are rendered to stdout.
+type outputFormat string
+
+const (
+    formatText   outputFormat = "text"
+    formatJSON   outputFormat = "json"
+    formatNDJSON outputFormat = "ndjson"
+    formatCSV    outputFormat = "csv"
+)
+
+// crawlJob is a single unit of work: a URL to fetch, how deep it was
+// discovered, and the host of the seed URL it originated from.
+type crawlJob struct {
+    url      string
+    depth    int
+    seedHost string
+}
+
+// crawlOptions configures the -crawl subsystem.
+type crawlOptions struct {
+    enabled  bool
+    maxDepth int
+    sameHost bool
+    visited  *sync.Map
+}
+
+// crawlQueue is an unbounded FIFO work queue. Unlike a fixed-size buffered
+// channel, pushing never blocks, so workers can freely enqueue newly
+// discovered links without risking a deadlock against the very channel
+// they're reading from. It closes itself once every pushed job (and every
+// job it transitively spawned) has been processed and the seed reader has
+// finished, which is how the crawl terminates when the visited set stops
+// growing.
+type crawlQueue struct {
+    mu         sync.Mutex
+    cond       *sync.Cond
+    items      []crawlJob
+    pending    int
+    readerDone bool
+    closed     bool
+}
+
+func newCrawlQueue() *crawlQueue {
+    q := &crawlQueue{}
+    q.cond = sync.NewCond(&q.mu)
+    return q
+}
+
+// push adds a job to the queue. Call once per job before it is handed to a
+// worker; pairs with a later done() call once that job has been processed.
+// Children pushed while processing it are counted as pending on their own.
+func (q *crawlQueue) push(job crawlJob) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.items = append(q.items, job)
+    q.pending++
+    q.cond.Broadcast()
+}
+
+// pop blocks until a job is available or the queue has closed.
+func (q *crawlQueue) pop() (crawlJob, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for len(q.items) == 0 && !q.closed {
+        q.cond.Wait()
+    }
+    if len(q.items) == 0 {
+        return crawlJob{}, false
+    }
+    job := q.items[0]
+    q.items = q.items[1:]
+    return job, true
+}
+
+// done marks one popped job as handled, closing the queue once nothing is
+// left pending (children the job pushed included) and the seed reader has
+// finished.
+func (q *crawlQueue) done() {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.pending--
+    q.closeIfDrained()
+}
+
+// markReaderDone signals that no further seed URLs will be pushed.
+func (q *crawlQueue) markReaderDone() {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.readerDone = true
+    q.closeIfDrained()
+}
+
+func (q *crawlQueue) closeIfDrained() {
+    if q.pending == 0 && q.readerDone {
+        q.closed = true
+        q.cond.Broadcast()
+    }
+}
+
+// redirectHop records one hop of a followed (or refused) redirect chain.
+type redirectHop struct {
+    StatusCode int    `json:"status_code"`
+    Location   string `json:"location"`
+}
+
 type result struct {
-    url, title, err string
-    responseCode    int
+    URL           string        `json:"url"`
+    FinalURL      string        `json:"final_url,omitempty"`
+    StatusCode    int           `json:"status_code,omitempty"`
+    Title         string        `json:"title,omitempty"`
+    ContentType   string        `json:"content_type,omitempty"`
+    ContentLength int64         `json:"content_length,omitempty"`
+    Redirects     []redirectHop `json:"redirects,omitempty"`
+    ElapsedMs     int64         `json:"elapsed_ms"`
+    Err           string        `json:"error,omitempty"`
+}
+
+// redirectOptions configures how the client follows (or refuses to follow)
+// HTTP redirects.
+type redirectOptions struct {
+    follow       bool
+    maxRedirects int
+}
+
+type contextKey string
+
+const redirectChainContextKey contextKey = "redirectChain"
+
+// maxRedirectsError is returned by checkRedirect once a chain exceeds
+// -max-redirects. It's a distinct type (rather than a plain fmt.Errorf) so
+// fetchWithRetry can recognize this as the deterministic failure it is and
+// skip retrying a URL that will never redirect any differently.
+type maxRedirectsError struct {
+    max int
+}
+
+func (e *maxRedirectsError) Error() string {
+    return fmt.Sprintf("stopped after %d redirects", e.max)
+}
+
+// checkRedirect builds an http.Client.CheckRedirect func for the given
+// options. When follow is false it returns http.ErrUseLastResponse so the
+// client hands back the 3xx response itself instead of chasing Location.
+// When follow is true it refuses past maxRedirects and, on every hop,
+// records the chain so far into the *[]redirectHop stashed in the original
+// request's context.
+func checkRedirect(opts redirectOptions) func(req *http.Request, via []*http.Request) error {
+    return func(req *http.Request, via []*http.Request) error {
+        if !opts.follow {
+            return http.ErrUseLastResponse
+        }
+        if len(via) >= opts.maxRedirects {
+            return &maxRedirectsError{max: opts.maxRedirects}
+        }
+        if chainPtr, ok := req.Context().Value(redirectChainContextKey).(*[]redirectHop); ok {
+            chain := make([]redirectHop, 0, len(via))
+            for _, prev := range via {
+                if prev.Response != nil {
+                    chain = append(chain, redirectHop{
+                        StatusCode: prev.Response.StatusCode,
+                        Location:   prev.Response.Header.Get("Location"),
+                    })
+                }
+            }
+            if req.Response != nil {
+                chain = append(chain, redirectHop{
+                    StatusCode: req.Response.StatusCode,
+                    Location:   req.Response.Header.Get("Location"),
+                })
+            }
+            *chainPtr = chain
+        }
+        return nil
+    }
+}
+
+// metricsRecorder exposes Prometheus counters/gauges/histograms for a
+// long-running scan. A nil *metricsRecorder is valid and every method is a
+// no-op, so callers don't need to branch on whether -metrics was set.
+type metricsRecorder struct {
+    requestsTotal *prometheus.CounterVec
+    errorsTotal   *prometheus.CounterVec
+    inFlight      prometheus.Gauge
+    duration      prometheus.Histogram
+}
+
+func newMetricsRecorder() *metricsRecorder {
+    return &metricsRecorder{
+        requestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+            Namespace: "titlextractor",
+            Name:      "requests_total",
+            Help:      "Completed requests, by status class (2xx, 3xx, ...).",
+        }, []string{"status_class"}),
+        errorsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+            Namespace: "titlextractor",
+            Name:      "errors_total",
+            Help:      "Requests that failed before a response was received, by error kind.",
+        }, []string{"kind"}),
+        inFlight: promauto.NewGauge(prometheus.GaugeOpts{
+            Namespace: "titlextractor",
+            Name:      "in_flight",
+            Help:      "Requests currently in flight.",
+        }),
+        duration: promauto.NewHistogram(prometheus.HistogramOpts{
+            Namespace: "titlextractor",
+            Name:      "request_duration_seconds",
+            Help:      "Request latency in seconds, including retries.",
+            Buckets:   prometheus.DefBuckets,
+        }),
+    }
+}
+
+// errorKind buckets an error into a coarse label for the errors_total metric.
+func errorKind(err error) string {
+    if os.IsTimeout(err) {
+        return "timeout"
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) {
+        return "network"
+    }
+    return "other"
+}
+
+func (m *metricsRecorder) observe(statusCode int, failure error, elapsed time.Duration) {
+    if m == nil {
+        return
+    }
+    m.duration.Observe(elapsed.Seconds())
+    if failure != nil {
+        m.errorsTotal.WithLabelValues(errorKind(failure)).Inc()
+        return
+    }
+    m.requestsTotal.WithLabelValues(fmt.Sprintf("%dxx", statusCode/100)).Inc()
+}
+
+func (m *metricsRecorder) trackInFlight() func() {
+    if m == nil {
+        return func() {}
+    }
+    m.inFlight.Inc()
+    return m.inFlight.Dec
+}
+
+// startMetricsServer exposes /metrics on addr in the background.
+func startMetricsServer(addr string) {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    go func() {
+        if err := http.ListenAndServe(addr, mux); err != nil {
+            fmt.Fprintf(os.Stderr, "[Metrics] %s\n", err)
+        }
+    }()
+}
+
+// progress renders processed/total/rate/ETA on stderr while a scan runs: a
+// proper bar when the input's total URL count is known up front (a
+// seekable file), a bare spinner otherwise (a pipe, whose length can't be
+// known without consuming it).
+type progress struct {
+    total     int64
+    processed int64
+    start     time.Time
+}
+
+func newProgress(total int64) *progress {
+    return &progress{total: total, start: time.Now()}
+}
+
+func (p *progress) inc() {
+    if p == nil {
+        return
+    }
+    atomic.AddInt64(&p.processed, 1)
+}
+
+func (p *progress) run(stop <-chan struct{}) {
+    frames := []string{"|", "/", "-", "\\"}
+    ticker := time.NewTicker(200 * time.Millisecond)
+    defer ticker.Stop()
+    frame := 0
+    for {
+        select {
+        case <-stop:
+            fmt.Fprint(os.Stderr, "\r\033[K")
+            return
+        case <-ticker.C:
+            processed := atomic.LoadInt64(&p.processed)
+            rate := float64(processed) / time.Since(p.start).Seconds()
+            if p.total > 0 {
+                eta := time.Duration(0)
+                if rate > 0 {
+                    eta = time.Duration(float64(p.total-processed)/rate) * time.Second
+                }
+                fmt.Fprintf(os.Stderr, "\r%d/%d (%.1f/s, ETA %s)  ", processed, p.total, rate, eta.Round(time.Second))
+            } else {
+                fmt.Fprintf(os.Stderr, "\r%s processed %d (%.1f/s)  ", frames[frame%len(frames)], processed, rate)
+                frame++
+            }
+        }
+    }
+}
+
+// stderrIsTTY reports whether os.Stderr is attached to a terminal.
+func stderrIsTTY() bool {
+    info, err := os.Stderr.Stat()
+    if err != nil {
+        return false
+    }
+    return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// countSeekableLines pre-computes the number of non-blank lines in f and
+// rewinds it, or reports ok=false if f isn't seekable (e.g. a pipe).
+func countSeekableLines(f *os.File) (count int64, ok bool) {
+    info, err := f.Stat()
+    if err != nil || info.Mode()&os.ModeNamedPipe != 0 {
+        return 0, false
+    }
+    if _, err := f.Seek(0, io.SeekCurrent); err != nil {
+        return 0, false
+    }
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+    for scanner.Scan() {
+        if strings.TrimSpace(scanner.Text()) != "" {
+            count++
+        }
+    }
+    if _, err := f.Seek(0, io.SeekStart); err != nil {
+        return 0, false
+    }
+    return count, true
+}
+
+// resolveOverrides implements flag.Value so -resolve can be repeated, a la
+// curl's --resolve, to dial a chosen IP for a given host without touching
+// DNS or the Host/SNI the target sees.
+type resolveOverrides map[string]string
+
+func (r resolveOverrides) String() string {
+    pairs := make([]string, 0, len(r))
+    for host, ip := range r {
+        pairs = append(pairs, host+":"+ip)
+    }
+    return strings.Join(pairs, ",")
+}
+
+func (r resolveOverrides) Set(value string) error {
+    idx := strings.LastIndex(value, ":")
+    if idx <= 0 || idx == len(value)-1 {
+        return fmt.Errorf("expected host:ip, got %q", value)
+    }
+    r[value[:idx]] = value[idx+1:]
+    return nil
+}
+
+// dialContextWithOverrides wraps dialer.DialContext so that hosts present
+// in overrides are dialed at the given IP instead of their resolved
+// address, while leaving addr (and therefore TLS SNI / the Host header)
+// untouched.
+func dialContextWithOverrides(dialer *net.Dialer, overrides resolveOverrides) func(ctx context.Context, network, addr string) (net.Conn, error) {
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        host, port, err := net.SplitHostPort(addr)
+        if err == nil {
+            if ip, ok := overrides[host]; ok {
+                addr = net.JoinHostPort(ip, port)
+            }
+        }
+        return dialer.DialContext(ctx, network, addr)
+    }
+}
+
+// buildTLSConfig translates the -tls flag ("verify", "skip", or
+// "pinned:<sha256>") into a *tls.Config. "pinned" skips the normal chain
+// check and instead requires the leaf certificate's SHA-256 fingerprint to
+// match exactly, so a scan can target a host with an otherwise-untrusted
+// cert as long as it's the expected one.
+func buildTLSConfig(mode, sni string) (*tls.Config, error) {
+    cfg := &tls.Config{ServerName: sni}
+    switch {
+    case mode == "verify" || mode == "":
+        // default Go verification against the system roots
+    case mode == "skip":
+        cfg.InsecureSkipVerify = true
+    case strings.HasPrefix(mode, "pinned:"):
+        want := strings.ToLower(strings.TrimPrefix(mode, "pinned:"))
+        cfg.InsecureSkipVerify = true
+        cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+            if len(rawCerts) == 0 {
+                return fmt.Errorf("no certificate presented")
+            }
+            sum := sha256.Sum256(rawCerts[0])
+            if hex.EncodeToString(sum[:]) != want {
+                return fmt.Errorf("certificate fingerprint mismatch: got %s, want %s", hex.EncodeToString(sum[:]), want)
+            }
+            return nil
+        }
+    default:
+        return nil, fmt.Errorf("unknown -tls mode %q: must be verify, skip, or pinned:<sha256>", mode)
+    }
+    return cfg, nil
+}
+
+// fallbackRoundTripTimeout bounds the fallback attempt's own context, since
+// it can't reuse whatever was left of the caller's deadline (see below).
+const fallbackRoundTripTimeout = 12 * time.Second
+
+// fallbackRoundTripper tries primary first and, if it errors (e.g. the
+// target doesn't speak QUIC), retries the request over fallback. This is
+// how -http3 degrades to H2/H1 for targets that aren't HTTP/3-capable.
+//
+// The fallback attempt gets its own fresh context rather than reusing
+// req's: the most common "no HTTP/3 here" failure is a silently-dropped
+// QUIC handshake that blocks until req's context deadline fires, so by the
+// time primary.RoundTrip returns there's no budget left for fallback to
+// actually try H2/H1.
+type fallbackRoundTripper struct {
+    primary  http.RoundTripper
+    fallback http.RoundTripper
+}
+
+func (f *fallbackRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := f.primary.RoundTrip(req)
+    if err == nil {
+        return resp, nil
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), fallbackRoundTripTimeout)
+    fallbackResp, fallbackErr := f.fallback.RoundTrip(req.Clone(ctx))
+    if fallbackErr != nil {
+        cancel()
+        return nil, fallbackErr
+    }
+    fallbackResp.Body = &cancelOnCloseBody{ReadCloser: fallbackResp.Body, cancel: cancel}
+    return fallbackResp, nil
 }
 
-func getTitle(body io.ReadCloser) string {
-    defer body.Close()
+// cancelOnCloseBody releases a context's resources once the body reading
+// it is closed, instead of leaking them until the context's own deadline.
+type cancelOnCloseBody struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.cancel()
+    return err
+}
+
+// hostLimiter hands out a per-host token-bucket limiter so concurrent
+// workers pulling from the shared queue don't hammer any one target faster
+// than rps, even though they may be fetching many different hosts at once.
+// A zero rps disables limiting entirely.
+type hostLimiter struct {
+    mu       sync.Mutex
+    rps      float64
+    limiters map[string]*rate.Limiter
+}
+
+func newHostLimiter(rps float64) *hostLimiter {
+    return &hostLimiter{rps: rps, limiters: make(map[string]*rate.Limiter)}
+}
+
+func (h *hostLimiter) wait(ctx context.Context, host string) error {
+    if h.rps <= 0 {
+        return nil
+    }
+    h.mu.Lock()
+    lim, ok := h.limiters[host]
+    if !ok {
+        lim = rate.NewLimiter(rate.Limit(h.rps), 1)
+        h.limiters[host] = lim
+    }
+    h.mu.Unlock()
+    return lim.Wait(ctx)
+}
+
+// retryOptions configures the backoff applied to transient failures.
+type retryOptions struct {
+    maxRetries int
+    baseDelay  time.Duration
+}
+
+// isRetryableStatus reports whether a response status is worth retrying:
+// rate limiting or a server-side failure, as opposed to a definitive 4xx.
+func isRetryableStatus(statusCode int) bool {
+    return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryAfterDelay parses a Retry-After header (seconds or HTTP-date form),
+// returning 0 if absent or unparsable.
+func retryAfterDelay(header http.Header) time.Duration {
+    value := header.Get("Retry-After")
+    if value == "" {
+        return 0
+    }
+    if secs, err := strconv.Atoi(value); err == nil {
+        return time.Duration(secs) * time.Second
+    }
+    if when, err := http.ParseTime(value); err == nil {
+        if d := time.Until(when); d > 0 {
+            return d
+        }
+    }
+    return 0
+}
+
+// backoffDelay computes the wait before the next retry attempt: the
+// server's Retry-After if it gave one, otherwise exponential backoff with
+// jitter (base * 2^attempt + rand[0,base)).
+func backoffDelay(base time.Duration, attempt int, retryAfter time.Duration) time.Duration {
+    if retryAfter > 0 {
+        return retryAfter
+    }
+    backoff := base * time.Duration(1<<uint(attempt))
+    return backoff + time.Duration(rand.Int63n(int64(base)))
+}
+
+// parsePage tokenizes an HTML body once, extracting both its <title> and
+// the absolute form of every <a href> it contains, resolved against base.
+func parsePage(body io.Reader, base *url.URL) (title string, links []string) {
+    title = "<title> tag missing"
+    titleFound := false
+    svgDepth := 0
     tokenizer := html.NewTokenizer(body)
-    title := "<title> tag missing"
     for {
         tokenType := tokenizer.Next()
         if tokenType == html.ErrorToken {
-            if tokenizer.Err() == io.EOF {
-                break
-            }
-            title = tokenizer.Err().Error()
             break
         }
-        if tokenType == html.StartTagToken {
-            token := tokenizer.Token()
-            if token.Data == "title" {
+        token := tokenizer.Token()
+        switch tokenType {
+        case html.EndTagToken:
+            if token.Data == "svg" && svgDepth > 0 {
+                svgDepth--
+            }
+            continue
+        case html.StartTagToken, html.SelfClosingTagToken:
+            // handled below
+        default:
+            continue
+        }
+        switch token.Data {
+        case "svg":
+            if tokenType == html.StartTagToken {
+                svgDepth++
+            }
+        case "title":
+            // Only the document's own <title> counts; an <svg><title> is a
+            // caption for the graphic, not the page title.
+            if !titleFound && svgDepth == 0 {
                 _ = tokenizer.Next()
                 title = tokenizer.Token().Data
-                break
+                titleFound = true
+            }
+        case "a":
+            for _, attr := range token.Attr {
+                if attr.Key != "href" {
+                    continue
+                }
+                if resolved := resolveLink(base, attr.Val); resolved != "" {
+                    links = append(links, resolved)
+                }
             }
         }
     }
-    return strings.TrimSpace(strings.Join(strings.Fields(title), " "))
+    return strings.TrimSpace(strings.Join(strings.Fields(title), " ")), links
 }
 
-func getWebContent(client *http.Client, wg *sync.WaitGroup, urls <-chan string, results chan<- result) {
-    defer wg.Done()
-    for url := range urls {
-        res := result{url: url}
+// resolveLink resolves href against base and returns it as an absolute
+// http(s) URL, or "" if href is empty, unparsable, or not http(s).
+func resolveLink(base *url.URL, href string) string {
+    href = strings.TrimSpace(href)
+    if href == "" || strings.HasPrefix(href, "#") {
+        return ""
+    }
+    parsed, err := url.Parse(href)
+    if err != nil {
+        return ""
+    }
+    resolved := parsed
+    if base != nil {
+        resolved = base.ResolveReference(parsed)
+    }
+    if resolved.Scheme != "http" && resolved.Scheme != "https" {
+        return ""
+    }
+    resolved.Fragment = ""
+    return resolved.String()
+}
+
+// decodeBody caps body at maxBody bytes and transcodes it to UTF-8, using
+// the response's Content-Type header and any <meta charset> it finds in
+// the first kilobyte to determine the source encoding.
+func decodeBody(response *http.Response, maxBody int64) io.Reader {
+    limited := io.LimitReader(response.Body, maxBody)
+    utf8Reader, err := charset.NewReader(limited, response.Header.Get("Content-Type"))
+    if err != nil {
+        return limited
+    }
+    return utf8Reader
+}
+
+// fetchWithRetry issues the request for job, waiting on limiter's per-host
+// bucket before every attempt and retrying transient failures (network
+// errors, 429, 5xx) up to retry.maxRetries times with backoff. Once the
+// retries are used up, a 429/5xx response is returned as the response.
+func fetchWithRetry(client *http.Client, limiter *hostLimiter, retry retryOptions, job crawlJob) (*http.Response, []redirectHop, error) {
+    host := job.seedHost
+    if parsed, err := url.Parse(job.url); err == nil {
+        host = parsed.Host
+    }
 
+    var lastErr error
+    for attempt := 0; ; attempt++ {
+        if err := limiter.wait(context.Background(), host); err != nil {
+            return nil, nil, err
+        }
+
+        var chain []redirectHop
         ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
-        req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+        ctx = context.WithValue(ctx, redirectChainContextKey, &chain)
+        req, err := http.NewRequestWithContext(ctx, "GET", job.url, nil)
         if err != nil {
-            res.err = err.Error()
-            results <- res
             cancel()
-            continue
+            return nil, nil, err
         }
 
         response, err := client.Do(req)
-        cancel() // Cancel the context whether it failed or succeeded
+        cancel()
+
+        if err == nil && (!isRetryableStatus(response.StatusCode) || attempt >= retry.maxRetries) {
+            return response, chain, nil
+        }
+
+        var maxRedirErr *maxRedirectsError
+        if errors.As(err, &maxRedirErr) {
+            return nil, nil, err
+        }
+
+        var retryAfter time.Duration
+        if err == nil {
+            lastErr = fmt.Errorf("status %d", response.StatusCode)
+            retryAfter = retryAfterDelay(response.Header)
+            response.Body.Close()
+        } else {
+            lastErr = err
+        }
+
+        if attempt >= retry.maxRetries {
+            return nil, nil, lastErr
+        }
+        time.Sleep(backoffDelay(retry.baseDelay, attempt, retryAfter))
+    }
+}
+
+func getWebContent(client *http.Client, wg *sync.WaitGroup, queue *crawlQueue, crawl crawlOptions, limiter *hostLimiter, retry retryOptions, maxBody int64, metrics *metricsRecorder, results chan<- result) {
+    defer wg.Done()
+    for {
+        job, ok := queue.pop()
+        if !ok {
+            return
+        }
+        res := result{URL: job.url}
+        start := time.Now()
 
+        done := metrics.trackInFlight()
+        response, chain, err := fetchWithRetry(client, limiter, retry, job)
+        done()
+        elapsed := time.Since(start)
         if err != nil {
-            res.err = err.Error()
+            res.Err = err.Error()
+            res.ElapsedMs = elapsed.Milliseconds()
+            metrics.observe(0, err, elapsed)
             results <- res
+            queue.done()
             continue
         }
 
-        res.responseCode = response.StatusCode
-        res.title = getTitle(response.Body)
+        var baseURL *url.URL
+        if response.Request != nil && response.Request.URL != nil {
+            baseURL = response.Request.URL
+            res.FinalURL = baseURL.String()
+        }
+        res.StatusCode = response.StatusCode
+        res.ContentType = response.Header.Get("Content-Type")
+        res.ContentLength = response.ContentLength
+        res.Redirects = chain
+
+        if len(chain) == 0 && response.StatusCode >= 300 && response.StatusCode < 400 {
+            // CheckRedirect returned http.ErrUseLastResponse (or there was
+            // nowhere to redirect to): this is the original 3xx, untouched.
+            res.Redirects = []redirectHop{{StatusCode: response.StatusCode, Location: response.Header.Get("Location")}}
+        } else if crawl.enabled && job.depth < crawl.maxDepth {
+            title, links := parsePage(decodeBody(response, maxBody), baseURL)
+            res.Title = title
+            for _, link := range links {
+                enqueueCrawlLink(queue, crawl, job, link)
+            }
+        } else {
+            title, _ := parsePage(decodeBody(response, maxBody), baseURL)
+            res.Title = title
+        }
+        _ = response.Body.Close()
+
+        totalElapsed := time.Since(start)
+        res.ElapsedMs = totalElapsed.Milliseconds()
+        metrics.observe(res.StatusCode, nil, totalElapsed)
         results <- res
+        queue.done()
     }
 }
 
+// enqueueCrawlLink pushes link onto the queue as a child of job, honoring
+// the same-host restriction and the visited-set dedup.
+func enqueueCrawlLink(queue *crawlQueue, crawl crawlOptions, job crawlJob, link string) {
+    if crawl.sameHost {
+        parsed, err := url.Parse(link)
+        if err != nil || parsed.Host != job.seedHost {
+            return
+        }
+    }
+    if _, seen := crawl.visited.LoadOrStore(link, true); seen {
+        return
+    }
+    queue.push(crawlJob{url: link, depth: job.depth + 1, seedHost: job.seedHost})
+}
+
 func colorForStatusCode(statusCode int) string {
     switch {
     case statusCode >= 200 && statusCode < 300:
@@ -99,12 +787,123 @@ func colorForStatusCode(statusCode int) string {
     }
 }
 
+// stdoutIsTTY reports whether os.Stdout is attached to a terminal.
+func stdoutIsTTY() bool {
+    info, err := os.Stdout.Stat()
+    if err != nil {
+        return false
+    }
+    return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// printText writes a single result in the classic human-readable format.
+func printText(res result, useColor bool) {
+    color, reset := "", ""
+    if useColor {
+        color, reset = colorForStatusCode(res.StatusCode), colorReset
+    }
+    if res.Err != "" {
+        if useColor {
+            color = colorMagenta
+        }
+        fmt.Printf("%s[Error] %s: %s%s\n", color, res.URL, res.Err, reset)
+    } else if len(res.Redirects) > 0 && res.Title == "" {
+        hop := res.Redirects[len(res.Redirects)-1]
+        fmt.Printf("%s[%d] %s: -> %s%s\n", color, res.StatusCode, res.URL, hop.Location, reset)
+    } else {
+        fmt.Printf("%s[%d] %s: %s%s\n", color, res.StatusCode, res.URL, res.Title, reset)
+    }
+}
+
+// writeCSVRow writes a single result as a CSV row, quoting as needed.
+func writeCSVRow(w *csv.Writer, res result) error {
+    return w.Write([]string{
+        res.URL,
+        res.FinalURL,
+        strconv.Itoa(res.StatusCode),
+        res.Title,
+        res.ContentType,
+        strconv.FormatInt(res.ContentLength, 10),
+        strconv.FormatInt(res.ElapsedMs, 10),
+        res.Err,
+    })
+}
+
 func main() {
     var concurrent int
+    var format string
+    var noColor bool
+    var crawlEnabled bool
+    var crawlDepth int
+    var sameHost bool
+    var followRedirects bool
+    var maxRedirects int
+    var maxBody int64
+    var rps float64
+    var retries int
+    var tlsMode string
+    var sni string
+    var useHTTP3 bool
+    var metricsAddr string
+    resolve := resolveOverrides{}
     flag.IntVar(&concurrent, "c", 5, "Number of concurrent workers")
+    flag.StringVar(&format, "o", string(formatText), "Output format: text|json|ndjson|csv")
+    flag.BoolVar(&noColor, "no-color", false, "Disable ANSI colors")
+    flag.BoolVar(&crawlEnabled, "crawl", false, "Recursively follow <a href> links found on each page")
+    flag.IntVar(&crawlDepth, "depth", 1, "Maximum link-following depth when -crawl is set")
+    flag.BoolVar(&sameHost, "same-host", false, "Restrict crawled links to each seed URL's host")
+    flag.BoolVar(&followRedirects, "follow-redirects", true, "Follow HTTP redirects")
+    flag.IntVar(&maxRedirects, "max-redirects", 10, "Maximum redirects to follow when -follow-redirects is set")
+    flag.Int64Var(&maxBody, "max-body", 512*1024, "Maximum response bytes read while looking for </title>")
+    flag.Float64Var(&rps, "rps", 0, "Requests per second, per host (0 = unlimited)")
+    flag.IntVar(&retries, "retry", 0, "Retries for network errors, 429s and 5xxs, with exponential backoff")
+    flag.StringVar(&tlsMode, "tls", "verify", "TLS verification: verify|skip|pinned:<sha256>")
+    flag.StringVar(&sni, "sni", "", "Override the TLS server name (SNI) sent to the target")
+    flag.Var(resolve, "resolve", "Dial host:ip instead of resolving host via DNS (repeatable)")
+    flag.BoolVar(&useHTTP3, "http3", false, "Try HTTP/3 (QUIC) first, falling back to H2/H1 on failure")
+    flag.StringVar(&metricsAddr, "metrics", "", "Serve Prometheus metrics on this address (e.g. :9090); disabled if empty")
     flag.Parse()
 
-    urls := make(chan string, concurrent*2)
+    outFormat := outputFormat(format)
+    switch outFormat {
+    case formatText, formatJSON, formatNDJSON, formatCSV:
+    default:
+        fmt.Fprintf(os.Stderr, "unknown output format %q: must be text, json, ndjson or csv\n", format)
+        os.Exit(1)
+    }
+
+    useColor := outFormat == formatText && !noColor && stdoutIsTTY()
+
+    var metrics *metricsRecorder
+    if metricsAddr != "" {
+        metrics = newMetricsRecorder()
+        startMetricsServer(metricsAddr)
+    }
+
+    var bar *progress
+    var barStop chan struct{}
+    if stderrIsTTY() {
+        var total int64
+        if !crawlEnabled {
+            // With -crawl, jobs are discovered as link-following goes, so the
+            // seed line count is not the eventual total; fall back to the
+            // spinner rather than show a bar that runs past 100% and drives
+            // the ETA negative.
+            total, _ = countSeekableLines(os.Stdin) // 0, not ok -> spinner mode
+        }
+        bar = newProgress(total)
+        barStop = make(chan struct{})
+        go bar.run(barStop)
+    }
+
+    crawl := crawlOptions{
+        enabled:  crawlEnabled,
+        maxDepth: crawlDepth,
+        sameHost: sameHost,
+        visited:  &sync.Map{},
+    }
+
+    queue := newCrawlQueue()
     results := make(chan result, concurrent*2)
 
     scanner := bufio.NewScanner(os.Stdin)
@@ -116,32 +915,59 @@ func main() {
     go func() {
         for scanner.Scan() {
             line := strings.TrimSpace(scanner.Text())
-            if line != "" {
-                urls <- line
+            if line == "" {
+                continue
+            }
+            seedHost := ""
+            if parsed, err := url.Parse(line); err == nil {
+                seedHost = parsed.Host
             }
+            crawl.visited.Store(line, true)
+            queue.push(crawlJob{url: line, depth: 0, seedHost: seedHost})
         }
         if err := scanner.Err(); err != nil {
             fmt.Fprintf(os.Stderr, "[Scanner Error] %s\n", err)
         }
-        close(urls)
+        queue.markReaderDone()
     }()
 
     var wg sync.WaitGroup
     wg.Add(concurrent)
 
+    tlsConfig, err := buildTLSConfig(tlsMode, sni)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+
+    dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+    transport := &http.Transport{
+        DialContext:     dialContextWithOverrides(dialer, resolve),
+        TLSClientConfig: tlsConfig,
+    }
+    if err := http2.ConfigureTransport(transport); err != nil {
+        fmt.Fprintf(os.Stderr, "[HTTP/2] %s\n", err)
+    }
+
+    var rt http.RoundTripper = transport
+    if useHTTP3 {
+        rt = &fallbackRoundTripper{
+            primary:  &http3.RoundTripper{TLSClientConfig: tlsConfig},
+            fallback: transport,
+        }
+    }
+
     client := &http.Client{
-        Timeout: 15 * time.Second,
-        Transport: &http.Transport{
-            DialContext: (&net.Dialer{
-                Timeout:   5 * time.Second,
-                KeepAlive: 30 * time.Second,
-            }).DialContext,
-            TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-        },
+        Timeout:       15 * time.Second,
+        Transport:     rt,
+        CheckRedirect: checkRedirect(redirectOptions{follow: followRedirects, maxRedirects: maxRedirects}),
     }
 
+    limiter := newHostLimiter(rps)
+    retry := retryOptions{maxRetries: retries, baseDelay: 500 * time.Millisecond}
+
     for i := 0; i < concurrent; i++ {
-        go getWebContent(client, &wg, urls, results)
+        go getWebContent(client, &wg, queue, crawl, limiter, retry, maxBody, metrics, results)
     }
 
     go func() {
@@ -149,12 +975,44 @@ func main() {
         close(results)
     }()
 
-    for res := range results {
-        color := colorForStatusCode(res.responseCode)
-        if res.err != "" {
-            fmt.Printf("%s[Error] %s: %s%s\n", colorMagenta, res.url, res.err, colorReset)
-        } else {
-            fmt.Printf("%s[%d] %s: %s%s\n", color, res.responseCode, res.url, res.title, colorReset)
+    if bar != nil {
+        defer close(barStop)
+    }
+
+    switch outFormat {
+    case formatJSON:
+        all := make([]result, 0, concurrent*2)
+        for res := range results {
+            bar.inc()
+            all = append(all, res)
+        }
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(all); err != nil {
+            fmt.Fprintf(os.Stderr, "[Encode Error] %s\n", err)
+        }
+    case formatNDJSON:
+        enc := json.NewEncoder(os.Stdout)
+        for res := range results {
+            bar.inc()
+            if err := enc.Encode(res); err != nil {
+                fmt.Fprintf(os.Stderr, "[Encode Error] %s\n", err)
+            }
+        }
+    case formatCSV:
+        w := csv.NewWriter(os.Stdout)
+        _ = w.Write([]string{"url", "final_url", "status_code", "title", "content_type", "content_length", "elapsed_ms", "error"})
+        for res := range results {
+            bar.inc()
+            if err := writeCSVRow(w, res); err != nil {
+                fmt.Fprintf(os.Stderr, "[CSV Error] %s\n", err)
+            }
+        }
+        w.Flush()
+    default:
+        for res := range results {
+            bar.inc()
+            printText(res, useColor)
         }
     }
 }